@@ -0,0 +1,115 @@
+package process
+
+import (
+	"context"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// bulkAppender is a storage.Appender that buffers appended samples and
+// routes them through the component's WasmPlugin in batches, amortizing
+// the host/guest call overhead, before forwarding the (possibly filtered
+// or reshaped) result to next.
+type bulkAppender struct {
+	ctx          context.Context
+	wasm         *WasmPlugin
+	next         storage.Appender
+	batchSize    int
+	batchMaxWait time.Duration
+
+	pending      []Sample
+	pendingSince time.Time
+
+	timeMetric                 prom.Counter
+	prometheusRecordsProcessed prom.Counter
+	batchSizeHist              prom.Histogram
+	callDuration               prom.Histogram
+}
+
+func (a *bulkAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if len(a.pending) == 0 {
+		a.pendingSince = time.Now()
+	}
+	a.pending = append(a.pending, Sample{Labels: l, Timestamp: t, Value: v})
+
+	full := a.batchSize > 0 && len(a.pending) >= a.batchSize
+	stale := a.batchMaxWait > 0 && time.Since(a.pendingSince) >= a.batchMaxWait
+	if full || stale {
+		if err := a.flush(); err != nil {
+			return ref, err
+		}
+	}
+	return ref, nil
+}
+
+// flush sends every buffered sample to the guest module in a single call
+// and forwards whatever it returns to next.
+func (a *bulkAppender) flush() error {
+	if len(a.pending) == 0 {
+		return nil
+	}
+	batch := a.pending
+	a.pending = nil
+
+	start := time.Now()
+	out, err := a.wasm.ProcessBatch(a.ctx, batch)
+	duration := time.Since(start)
+	a.callDuration.Observe(duration.Seconds())
+	a.batchSizeHist.Observe(float64(len(batch)))
+	a.timeMetric.Add(float64(duration.Milliseconds()))
+	if err != nil {
+		return err
+	}
+
+	var ref storage.SeriesRef
+	for _, s := range out {
+		ref, err = a.next.Append(ref, s.Labels, s.Timestamp, s.Value)
+		if err != nil {
+			return err
+		}
+		a.prometheusRecordsProcessed.Inc()
+
+		for _, e := range s.Exemplars {
+			if _, err := a.next.AppendExemplar(ref, s.Labels, exemplar.Exemplar{
+				Labels: e.Labels,
+				Value:  e.Value,
+				Ts:     e.Timestamp,
+				HasTs:  e.HasTimestamp,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *bulkAppender) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+	return a.next.AppendExemplar(ref, l, e)
+}
+
+func (a *bulkAppender) UpdateMetadata(ref storage.SeriesRef, l labels.Labels, m metadata.Metadata) (storage.SeriesRef, error) {
+	return a.next.UpdateMetadata(ref, l, m)
+}
+
+func (a *bulkAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return a.next.AppendHistogram(ref, l, t, h, fh)
+}
+
+func (a *bulkAppender) Commit() error {
+	if err := a.flush(); err != nil {
+		a.next.Rollback()
+		return err
+	}
+	return a.next.Commit()
+}
+
+func (a *bulkAppender) Rollback() error {
+	a.pending = nil
+	return a.next.Rollback()
+}
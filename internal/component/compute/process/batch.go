@@ -0,0 +1,179 @@
+package process
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// batchSchemaVersion is bumped whenever wireBatch's wire-compatible layout
+// changes, so a guest compiled against an older schema can detect a
+// mismatch instead of misparsing the payload.
+const batchSchemaVersion = 1
+
+// wireBatch is the MessagePack envelope exchanged with process_batch. It
+// mirrors Sample/Exemplar, using plain maps instead of labels.Labels so
+// guests written in languages without an equivalent type can decode it.
+type wireBatch struct {
+	Version uint8        `msgpack:"version"`
+	Samples []wireSample `msgpack:"samples"`
+}
+
+type wireSample struct {
+	Labels    map[string]string `msgpack:"labels"`
+	Timestamp int64             `msgpack:"ts"`
+	Value     float64           `msgpack:"value"`
+	Exemplars []wireExemplar    `msgpack:"exemplars,omitempty"`
+}
+
+type wireExemplar struct {
+	Labels       map[string]string `msgpack:"labels"`
+	Value        float64           `msgpack:"value"`
+	Timestamp    int64             `msgpack:"ts,omitempty"`
+	HasTimestamp bool              `msgpack:"has_ts,omitempty"`
+}
+
+// Exemplar is an exemplar attached to a Sample returned by ProcessBatch.
+type Exemplar struct {
+	Labels       labels.Labels
+	Value        float64
+	Timestamp    int64
+	HasTimestamp bool
+}
+
+func toWireBatch(samples []Sample) wireBatch {
+	wb := wireBatch{Version: batchSchemaVersion, Samples: make([]wireSample, len(samples))}
+	for i, s := range samples {
+		ws := wireSample{
+			Labels:    s.Labels.Map(),
+			Timestamp: s.Timestamp,
+			Value:     s.Value,
+		}
+		for _, e := range s.Exemplars {
+			ws.Exemplars = append(ws.Exemplars, wireExemplar{
+				Labels:       e.Labels.Map(),
+				Value:        e.Value,
+				Timestamp:    e.Timestamp,
+				HasTimestamp: e.HasTimestamp,
+			})
+		}
+		wb.Samples[i] = ws
+	}
+	return wb
+}
+
+func fromWireBatch(wb wireBatch) ([]Sample, error) {
+	if wb.Version != batchSchemaVersion {
+		return nil, fmt.Errorf("unsupported process_batch schema version %d, want %d", wb.Version, batchSchemaVersion)
+	}
+	out := make([]Sample, len(wb.Samples))
+	for i, ws := range wb.Samples {
+		s := Sample{
+			Labels:    labels.FromMap(ws.Labels),
+			Timestamp: ws.Timestamp,
+			Value:     ws.Value,
+		}
+		for _, we := range ws.Exemplars {
+			s.Exemplars = append(s.Exemplars, Exemplar{
+				Labels:       labels.FromMap(we.Labels),
+				Value:        we.Value,
+				Timestamp:    we.Timestamp,
+				HasTimestamp: we.HasTimestamp,
+			})
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// ProcessBatch hands a batch of samples to the guest's process_batch entry
+// point in one host/guest crossing and returns the zero or more samples it
+// produces, amortizing call overhead across the whole batch instead of
+// paying it per sample.
+func (p *WasmPlugin) ProcessBatch(ctx context.Context, samples []Sample) ([]Sample, error) {
+	inst, callCtx, release, err := p.checkout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bad := false
+	defer func() {
+		if release != nil {
+			release(bad)
+		}
+	}()
+
+	if inst.processBatch == nil {
+		// Older guests that only implement process_metrics don't understand
+		// the batch wire format; fall back to one call per sample so they
+		// keep working. A sample that faults its instance (fuel exhausted,
+		// trap, timeout) gets that instance recycled and a fresh one checked
+		// out for the rest of the batch, rather than retrapping the same
+		// already-faulted instance on every remaining sample under
+		// on_error = "passthrough".
+		var out []Sample
+		for _, s := range samples {
+			res, faulted, perr := p.processMetricsOn(callCtx, inst, s.Labels, s.Timestamp, s.Value)
+			if perr != nil {
+				bad = faulted
+				return nil, perr
+			}
+			out = append(out, res...)
+
+			if faulted {
+				release(true)
+				inst, callCtx, release, err = p.checkout(ctx)
+				if err != nil {
+					return nil, err
+				}
+				bad = false
+			}
+		}
+		return out, nil
+	}
+
+	in, err := msgpack.Marshal(toWireBatch(samples))
+	if err != nil {
+		bad = true
+		return onInstanceError(p, fmt.Errorf("encoding batch: %w", err), samples)
+	}
+
+	inPtr, err := inst.writeBytes(callCtx, in)
+	if err != nil {
+		bad = true
+		return onInstanceError(p, err, samples)
+	}
+	defer inst.free.Call(callCtx, inPtr)
+
+	res, err := inst.processBatch.Call(callCtx, inPtr, uint64(len(in)))
+	if err != nil {
+		bad = true
+		return onInstanceError(p, fmt.Errorf("calling process_batch: %w", err), samples)
+	}
+	outPtr, outLen := unpackUint64Result(res[0])
+	if outPtr != 0 {
+		defer inst.free.Call(callCtx, uint64(outPtr))
+	}
+	if outLen == 0 {
+		return nil, nil
+	}
+
+	out, err := inst.readBytes(outPtr, outLen)
+	if err != nil {
+		bad = true
+		return onInstanceError(p, err, samples)
+	}
+
+	var wb wireBatch
+	if err := msgpack.Unmarshal(out, &wb); err != nil {
+		bad = true
+		return onInstanceError(p, fmt.Errorf("decoding batch: %w", err), samples)
+	}
+	decoded, err := fromWireBatch(wb)
+	if err != nil {
+		bad = true
+		return onInstanceError(p, err, samples)
+	}
+	return decoded, nil
+}
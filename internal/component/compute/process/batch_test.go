@@ -0,0 +1,54 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToFromWireBatchRoundTrip(t *testing.T) {
+	samples := []Sample{
+		{
+			Labels:    labels.FromStrings("__name__", "up", "job", "node"),
+			Timestamp: 1000,
+			Value:     1.5,
+			Exemplars: []Exemplar{
+				{
+					Labels:       labels.FromStrings("trace_id", "abc123"),
+					Value:        2.5,
+					Timestamp:    999,
+					HasTimestamp: true,
+				},
+			},
+		},
+		{
+			Labels:    labels.EmptyLabels(),
+			Timestamp: 2000,
+			Value:     -3.25,
+		},
+	}
+
+	wb := toWireBatch(samples)
+	require.Equal(t, uint8(batchSchemaVersion), wb.Version)
+	require.Len(t, wb.Samples, len(samples))
+
+	out, err := fromWireBatch(wb)
+	require.NoError(t, err)
+	require.Equal(t, samples, out)
+}
+
+func TestFromWireBatchRejectsUnknownVersion(t *testing.T) {
+	_, err := fromWireBatch(wireBatch{Version: batchSchemaVersion + 1})
+	require.Error(t, err)
+}
+
+func TestToWireBatchEmpty(t *testing.T) {
+	wb := toWireBatch(nil)
+	require.Equal(t, uint8(batchSchemaVersion), wb.Version)
+	require.Empty(t, wb.Samples)
+
+	out, err := fromWireBatch(wb)
+	require.NoError(t, err)
+	require.Empty(t, out)
+}
@@ -0,0 +1,145 @@
+package process
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// hostModuleName is the namespace every compute.process host function is
+// exported under. It is documented as part of the guest ABI alongside the
+// process_metrics/process_logs/process_batch entry points in wasm.go.
+const hostModuleName = "alloy_compute"
+
+// Guest-visible log levels for the log host import, matching go-kit's
+// level package.
+const (
+	logLevelDebug uint32 = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+// buildHostModule registers the alloy_compute host imports available to
+// every guest module regardless of which entry point it implements:
+//
+//	log(level, ptr, len)
+//	now_unix_nanos() -> i64
+//	metric_emit(name_ptr, name_len, value, labels_ptr, labels_len)
+//	config_get(key_ptr, key_len) -> packed(ptr, len)
+//	consume_fuel(n) -- traps the call once inst's fuel budget is spent
+//
+// labels passed to metric_emit are plain "k=v,k=v" strings, matching
+// the rest of the ABI (see packLine/packSample in wasm.go). consume_fuel
+// is bound to inst, so each sandbox instance is metered independently.
+func (p *WasmPlugin) buildHostModule(ctx context.Context, rt wazero.Runtime, inst *wasmInstance) error {
+	_, err := rt.NewHostModuleBuilder(hostModuleName).
+		NewFunctionBuilder().
+		WithFunc(p.hostLog).
+		Export("log").
+		NewFunctionBuilder().
+		WithFunc(func() uint64 {
+			return uint64(time.Now().UnixNano())
+		}).
+		Export("now_unix_nanos").
+		NewFunctionBuilder().
+		WithFunc(p.hostMetricEmit).
+		Export("metric_emit").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod api.Module, keyPtr, keyLen uint32) uint64 {
+			return p.hostConfigGet(ctx, inst, mod, keyPtr, keyLen)
+		}).
+		Export("config_get").
+		NewFunctionBuilder().
+		WithFunc(inst.hostConsumeFuel).
+		Export("consume_fuel").
+		Instantiate(ctx)
+	return err
+}
+
+func (p *WasmPlugin) hostLog(_ context.Context, mod api.Module, logLevel, ptr, length uint32) {
+	msg, ok := mod.Memory().Read(ptr, length)
+	if !ok {
+		return
+	}
+	switch logLevel {
+	case logLevelDebug:
+		level.Debug(p.logger).Log("msg", string(msg), "source", "wasm_guest")
+	case logLevelWarn:
+		level.Warn(p.logger).Log("msg", string(msg), "source", "wasm_guest")
+	case logLevelError:
+		level.Error(p.logger).Log("msg", string(msg), "source", "wasm_guest")
+	default:
+		level.Info(p.logger).Log("msg", string(msg), "source", "wasm_guest")
+	}
+}
+
+// hostMetricEmit sets the guest metric (name, labels) to value. name and
+// labels come straight from guest memory, so the series they can together
+// create is capped via admitGuestMetricSeries.
+func (p *WasmPlugin) hostMetricEmit(_ context.Context, mod api.Module, namePtr, nameLen uint32, value float64, labelsPtr, labelsLen uint32) {
+	name, ok := mod.Memory().Read(namePtr, nameLen)
+	if !ok {
+		return
+	}
+	labelStr, ok := mod.Memory().Read(labelsPtr, labelsLen)
+	if !ok {
+		return
+	}
+	if !p.admitGuestMetricSeries(string(name), string(labelStr)) {
+		p.guestMetricSeriesDropped.Inc()
+		return
+	}
+	p.guestMetrics.WithLabelValues(string(name), string(labelStr)).Set(value)
+}
+
+func (p *WasmPlugin) hostConfigGet(ctx context.Context, inst *wasmInstance, mod api.Module, keyPtr, keyLen uint32) uint64 {
+	key, ok := mod.Memory().Read(keyPtr, keyLen)
+	if !ok {
+		return 0
+	}
+	val, ok := p.cfg[string(key)]
+	if !ok {
+		return 0
+	}
+	valPtr, err := inst.writeBytes(ctx, []byte(val))
+	if err != nil {
+		return 0
+	}
+	return packUint64Result(uint32(valPtr), uint32(len(val)))
+}
+
+// fuelExhaustedErr is panicked by hostConsumeFuel once an instance's fuel
+// budget is spent; wazero turns a host function panic into a trap, which
+// surfaces as an error from the in-flight Call.
+type fuelExhaustedErr struct{}
+
+func (fuelExhaustedErr) Error() string { return "wasm guest exceeded its fuel budget" }
+
+// hostConsumeFuel lets a guest report the compute units it has spent since
+// its last host call, so the host can enforce limits.fuel without needing
+// wazero's experimental low-level metering hooks. This makes limits.fuel
+// entirely guest-cooperative: a guest that never calls consume_fuel is
+// never charged against its budget, however much it actually computes.
+// limits.timeout (see hardCallCeiling in wasm.go) is what actually bounds
+// such a guest; limits.fuel is best understood as a budget a well-behaved
+// guest opts into, not an enforced ceiling.
+func (inst *wasmInstance) hostConsumeFuel(n uint64) {
+	if inst.fuelBudget == 0 {
+		return
+	}
+	if n > inst.fuelRemaining {
+		inst.fuelRemaining = 0
+		panic(fuelExhaustedErr{})
+	}
+	inst.fuelRemaining -= n
+}
+
+// packUint64Result is the inverse of unpackUint64Result, used by host
+// imports that return a variable-length buffer to the guest.
+func packUint64Result(ptr, length uint32) uint64 {
+	return uint64(ptr)<<32 | uint64(length)
+}
@@ -0,0 +1,33 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmitGuestMetricSeriesCapsDistinctSeries(t *testing.T) {
+	p := &WasmPlugin{guestMetricSeries: make(map[string]struct{})}
+
+	for i := 0; i < maxGuestMetricSeries; i++ {
+		require.True(t, p.admitGuestMetricSeries("m", labelsForIndex(i)))
+	}
+	require.Len(t, p.guestMetricSeries, maxGuestMetricSeries)
+
+	require.False(t, p.admitGuestMetricSeries("m", labelsForIndex(maxGuestMetricSeries)))
+	require.Len(t, p.guestMetricSeries, maxGuestMetricSeries)
+}
+
+func TestAdmitGuestMetricSeriesReadmitsSeenSeries(t *testing.T) {
+	p := &WasmPlugin{guestMetricSeries: make(map[string]struct{})}
+
+	for i := 0; i < maxGuestMetricSeries; i++ {
+		require.True(t, p.admitGuestMetricSeries("m", labelsForIndex(i)))
+	}
+
+	require.True(t, p.admitGuestMetricSeries("m", labelsForIndex(0)))
+}
+
+func labelsForIndex(i int) string {
+	return "n=" + string(rune(i))
+}
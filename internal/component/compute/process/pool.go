@@ -0,0 +1,303 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log/level"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// wasmPageSize is wazero's linear memory page size in bytes. A
+// limits.memory_bytes value is converted to a page count by rounding up to
+// this, rather than truncating, so that any value below one page doesn't
+// silently produce a 0-page (i.e. memory-less) limit that no real guest
+// could instantiate under.
+const wasmPageSize = 65536
+
+// wasmInstance is one pre-instantiated copy of the guest module, each with
+// its own wazero.Runtime and therefore its own isolated linear memory, so
+// that a panic, trap, or timeout in one instance can't corrupt another's
+// state. Instances compiled from the same bytes share a CompilationCache,
+// so recycling one after a fault is cheap.
+type wasmInstance struct {
+	runtime wazero.Runtime
+	module  api.Module
+
+	malloc         api.Function
+	free           api.Function
+	processMetrics api.Function
+	processLogs    api.Function
+	processBatch   api.Function
+
+	fuelBudget    uint64
+	fuelRemaining uint64
+}
+
+func (inst *wasmInstance) writeBytes(ctx context.Context, b []byte) (uint64, error) {
+	res, err := inst.malloc.Call(ctx, uint64(len(b)))
+	if err != nil {
+		return 0, fmt.Errorf("guest malloc: %w", err)
+	}
+	ptr := res[0]
+	if !inst.module.Memory().Write(uint32(ptr), b) {
+		return 0, fmt.Errorf("writing %d bytes to guest memory at 0x%x", len(b), ptr)
+	}
+	return ptr, nil
+}
+
+func (inst *wasmInstance) readBytes(ptr, length uint32) ([]byte, error) {
+	buf, ok := inst.module.Memory().Read(ptr, length)
+	if !ok {
+		return nil, fmt.Errorf("reading %d bytes from guest memory at 0x%x", length, ptr)
+	}
+	// Memory() returns a view into the guest's linear memory, which is
+	// reused across calls, so copy it out before it can be overwritten.
+	out := make([]byte, len(buf))
+	copy(out, buf)
+	return out, nil
+}
+
+func (inst *wasmInstance) close(ctx context.Context) {
+	if inst.runtime == nil {
+		return
+	}
+	_ = inst.runtime.Close(ctx)
+}
+
+// instancePool manages a fixed-size set of wasmInstances. Callers check
+// one out for the duration of a single call and return it afterward; an
+// instance that trapped, timed out, or exceeded its fuel budget is closed
+// and replaced rather than returned to the pool.
+type instancePool struct {
+	plugin *WasmPlugin
+	cache  wazero.CompilationCache
+
+	slots chan *wasmInstance
+	size  int
+
+	inUseCount atomic.Int32
+
+	// closing and closed coordinate closeAll with in-flight calls: once
+	// closing is set, release() closes its instance directly instead of
+	// recycling or returning it to slots, and signals closed so closeAll
+	// can wait for every checked-out instance to come back before
+	// declaring the pool fully torn down.
+	closing atomic.Bool
+	closed  chan struct{}
+
+	fullMut   sync.Mutex
+	fullSince time.Time
+
+	inUse    prom.Gauge
+	recycled prom.Counter
+	timeouts prom.Counter
+}
+
+// newInstancePool compiles wasm once and boots size pre-instantiated
+// copies of it, each sharing cache for fast recompilation on recycle.
+func newInstancePool(ctx context.Context, p *WasmPlugin, wasm []byte, size int) (*instancePool, error) {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+
+	pl := &instancePool{
+		plugin: p,
+		cache:  wazero.NewCompilationCache(),
+		slots:  make(chan *wasmInstance, size),
+		size:   size,
+		closed: make(chan struct{}, size),
+		inUse: prom.NewGauge(prom.GaugeOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_wasm_pool_in_use",
+			Help:      "Number of sandbox instances currently checked out of the pool.",
+		}),
+		recycled: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_wasm_instances_recycled_total",
+			Help:      "Number of sandbox instances dropped and replaced after exceeding a limit or trapping.",
+		}),
+		timeouts: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_wasm_timeouts_total",
+			Help:      "Number of calls into a sandbox instance that exceeded limits.timeout.",
+		}),
+	}
+
+	for i := 0; i < size; i++ {
+		inst, err := pl.newInstance(ctx, wasm)
+		if err != nil {
+			// Only i instances were ever started, and closeAll waits for
+			// pl.size of them to come back.
+			pl.size = i
+			pl.closeAll(ctx)
+			return nil, fmt.Errorf("starting sandbox instance %d/%d: %w", i+1, size, err)
+		}
+		pl.slots <- inst
+	}
+	return pl, nil
+}
+
+// newInstance compiles (cache-assisted) and instantiates one isolated copy
+// of wasm, wiring up the alloy_compute host imports bound to it.
+func (pl *instancePool) newInstance(ctx context.Context, wasm []byte) (*wasmInstance, error) {
+	rtCfg := wazero.NewRuntimeConfig().WithCompilationCache(pl.cache).WithCloseOnContextDone(true)
+	if mb := pl.plugin.limits.MemoryBytes; mb > 0 {
+		rtCfg = rtCfg.WithMemoryLimitPages((mb + wasmPageSize - 1) / wasmPageSize)
+	}
+	rt := wazero.NewRuntimeWithConfig(ctx, rtCfg)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("instantiating WASI: %w", err)
+	}
+
+	inst := &wasmInstance{runtime: rt, fuelBudget: pl.plugin.limits.Fuel}
+	if err := pl.plugin.buildHostModule(ctx, rt, inst); err != nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("registering alloy_compute host module: %w", err)
+	}
+
+	compiled, err := rt.CompileModule(ctx, wasm)
+	if err != nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("compiling wasm module: %w", err)
+	}
+
+	mod, err := rt.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithStartFunctions("_initialize"))
+	if err != nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("instantiating wasm module: %w", err)
+	}
+	inst.module = mod
+	inst.malloc = mod.ExportedFunction("malloc")
+	inst.free = mod.ExportedFunction("free")
+	inst.processMetrics = mod.ExportedFunction("process_metrics")
+	inst.processLogs = mod.ExportedFunction("process_logs")
+	inst.processBatch = mod.ExportedFunction("process_batch")
+
+	if inst.malloc == nil || inst.free == nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("wasm module must export malloc and free")
+	}
+
+	return inst, nil
+}
+
+// checkout blocks until an instance is available or ctx is done, and
+// resets the checked-out instance's fuel budget. It applies no deadline
+// itself; WasmPlugin.checkout adds limits.Timeout on top of it.
+func (pl *instancePool) checkout(ctx context.Context) (*wasmInstance, error) {
+	select {
+	case inst := <-pl.slots:
+		n := pl.inUseCount.Add(1)
+		pl.inUse.Inc()
+		if int(n) >= pl.size {
+			pl.fullMut.Lock()
+			if pl.fullSince.IsZero() {
+				pl.fullSince = time.Now()
+			}
+			pl.fullMut.Unlock()
+		}
+		inst.fuelRemaining = inst.fuelBudget
+		return inst, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// release returns inst to the pool, or - if bad is set because the call
+// trapped, timed out, or ran out of fuel - closes it and starts a
+// replacement in its place. If the pool is being torn down by closeAll,
+// inst is closed outright and closeAll is signaled instead, since there is
+// no longer a plugin generation left for it to serve.
+func (pl *instancePool) release(ctx context.Context, inst *wasmInstance, bad bool) {
+	n := pl.inUseCount.Add(-1)
+	pl.inUse.Dec()
+	if int(n) < pl.size {
+		pl.fullMut.Lock()
+		pl.fullSince = time.Time{}
+		pl.fullMut.Unlock()
+	}
+
+	if pl.closing.Load() {
+		inst.close(ctx)
+		select {
+		case pl.closed <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	if !bad {
+		pl.slots <- inst
+		return
+	}
+
+	pl.recycled.Inc()
+	inst.close(ctx)
+
+	fresh, err := pl.newInstance(ctx, pl.plugin.wasm)
+	if err != nil {
+		level.Error(pl.plugin.logger).Log("msg", "failed to recycle sandbox instance, pool permanently lost a slot", "err", err)
+		return
+	}
+	pl.slots <- fresh
+}
+
+// saturated reports whether every instance in the pool has been checked
+// out continuously for at least d.
+func (pl *instancePool) saturated(d time.Duration) bool {
+	pl.fullMut.Lock()
+	defer pl.fullMut.Unlock()
+	return !pl.fullSince.IsZero() && time.Since(pl.fullSince) >= d
+}
+
+// closeAll closes every instance in the pool, including ones currently
+// checked out by an in-flight call: it waits for those to be released
+// before returning. Closing pl.size instances unconditionally - rather than
+// only draining whatever happens to be idle in pl.slots - matters because a
+// reload that swaps out a pool under load would otherwise leak one wazero
+// runtime (and its goroutines/memory) per call still in flight at the
+// moment of the swap.
+func (pl *instancePool) closeAll(ctx context.Context) {
+	pl.closing.Store(true)
+
+	closed := 0
+	draining := true
+	for draining {
+		select {
+		case inst := <-pl.slots:
+			inst.close(ctx)
+			closed++
+		default:
+			draining = false
+		}
+	}
+
+	for closed < pl.size {
+		select {
+		case inst := <-pl.slots:
+			// A straggler whose release() observed pl.closing as false in
+			// the narrow window before the Store above became visible to
+			// it; treat it the same as one reported via pl.closed.
+			inst.close(ctx)
+		case <-pl.closed:
+		case <-ctx.Done():
+			pl.cache.Close(ctx)
+			return
+		}
+		closed++
+	}
+	pl.cache.Close(ctx)
+}
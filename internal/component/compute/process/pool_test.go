@@ -0,0 +1,144 @@
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+)
+
+// newTestPool builds an instancePool around fake, already-instantiated
+// wasmInstance values instead of going through newInstancePool, so the
+// pool's checkout/release/saturation bookkeeping can be exercised without
+// compiling a real wasm module. The fake instances have a nil
+// wazero.Runtime; wasmInstance.close no-ops on that, so it's safe for these
+// tests to drive closeAll. A bad release is not safe here: it recycles the
+// instance through pl.plugin, which these pools leave nil.
+func newTestPool(size int) *instancePool {
+	pl := &instancePool{
+		cache:  wazero.NewCompilationCache(),
+		slots:  make(chan *wasmInstance, size),
+		size:   size,
+		closed: make(chan struct{}, size),
+		inUse: prom.NewGauge(prom.GaugeOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_pool_in_use",
+		}),
+		recycled: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_pool_recycled",
+		}),
+		timeouts: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_pool_timeouts",
+		}),
+	}
+	for i := 0; i < size; i++ {
+		pl.slots <- &wasmInstance{}
+	}
+	return pl
+}
+
+func TestInstancePoolCheckoutTracksOccupancy(t *testing.T) {
+	pl := newTestPool(2)
+	ctx := context.Background()
+
+	inst1, err := pl.checkout(ctx)
+	require.NoError(t, err)
+	require.False(t, pl.saturated(0))
+
+	inst2, err := pl.checkout(ctx)
+	require.NoError(t, err)
+	require.True(t, pl.saturated(0))
+
+	pl.release(ctx, inst1, false)
+	require.False(t, pl.saturated(0))
+
+	pl.release(ctx, inst2, false)
+	require.False(t, pl.saturated(0))
+}
+
+func TestInstancePoolCheckoutBlocksUntilReleased(t *testing.T) {
+	pl := newTestPool(1)
+	ctx := context.Background()
+
+	inst, err := pl.checkout(ctx)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		got, err := pl.checkout(ctx)
+		require.NoError(t, err)
+		pl.release(ctx, got, false)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("checkout returned before the only instance was released")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pl.release(ctx, inst, false)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("checkout did not unblock after release")
+	}
+}
+
+func TestInstancePoolCheckoutRespectsContextCancellation(t *testing.T) {
+	pl := newTestPool(1)
+	ctx := context.Background()
+
+	_, err := pl.checkout(ctx)
+	require.NoError(t, err)
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = pl.checkout(cancelCtx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestInstancePoolSaturatedThreshold(t *testing.T) {
+	pl := newTestPool(1)
+	ctx := context.Background()
+
+	_, err := pl.checkout(ctx)
+	require.NoError(t, err)
+
+	require.False(t, pl.saturated(time.Hour))
+	require.True(t, pl.saturated(0))
+}
+
+func TestInstancePoolCloseAllWaitsForInFlightRelease(t *testing.T) {
+	pl := newTestPool(2)
+	ctx := context.Background()
+
+	// One instance idle in slots, one checked out to simulate an in-flight
+	// call at the moment the pool is torn down.
+	inst, err := pl.checkout(ctx)
+	require.NoError(t, err)
+
+	closeDone := make(chan struct{})
+	go func() {
+		defer close(closeDone)
+		pl.closeAll(ctx)
+	}()
+
+	select {
+	case <-closeDone:
+		t.Fatal("closeAll returned before the in-flight instance was released")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pl.release(ctx, inst, false)
+
+	select {
+	case <-closeDone:
+	case <-time.After(time.Second):
+		t.Fatal("closeAll did not return after the in-flight instance was released")
+	}
+}
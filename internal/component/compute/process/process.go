@@ -2,10 +2,14 @@ package process
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
 	"maps"
 	"slices"
 	"sync"
+	"time"
 
+	"github.com/go-kit/log/level"
 	"github.com/grafana/alloy/internal/component"
 	"github.com/grafana/alloy/internal/component/common/loki"
 	"github.com/grafana/alloy/internal/component/prometheus"
@@ -37,6 +41,27 @@ type Component struct {
 	ls                         labelstore.LabelStore
 	timeMetric                 prom.Counter
 	prometheusRecordsProcessed prom.Counter
+	lokiRecordsProcessed       prom.Counter
+	lokiRecordsDropped         prom.Counter
+	wasmReloadFailures         prom.Counter
+	wasmBatchSize              prom.Histogram
+	wasmCallDuration           prom.Histogram
+
+	// wasmRebuildMut serializes the read-old/unregister/NewPlugin/swap/
+	// closeAll sequence shared by Update and reload.go's reloadOnce (see
+	// rebuildWasm): they run on different goroutines (the component
+	// controller vs. reloadLoop) and would otherwise race to unregister and
+	// replace the same old plugin's metrics.
+	wasmRebuildMut sync.Mutex
+
+	healthMut           sync.RWMutex
+	health              component.Health
+	poolSaturatedHealth bool
+
+	// initialWasmHash seeds reloadLoop's change-detection hash when
+	// WasmSource is set, so the periodic reload started by Run doesn't
+	// treat the module already loaded synchronously below as "changed".
+	initialWasmHash [32]byte
 }
 
 func New(opts component.Options, args Arguments) (*Component, error) {
@@ -44,16 +69,38 @@ func New(opts component.Options, args Arguments) (*Component, error) {
 	if err != nil {
 		return nil, err
 	}
-	wp, err := NewPlugin(args.Wasm, args.Config, context.TODO())
+
+	// pluginArgs carries whatever bytes the plugin should actually run,
+	// whether they came from args.Wasm directly or from loading
+	// args.WasmSource once up front; c.args keeps the literal config so
+	// Update can keep comparing against what the user actually set.
+	pluginArgs := args
+	var initialWasmHash [32]byte
+	if args.WasmSource != nil {
+		loadCtx, cancel := context.WithTimeout(context.Background(), wasmSourceFetchTimeout)
+		wasm, err := args.WasmSource.load(loadCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("loading initial wasm module from wasm_source: %w", err)
+		}
+		pluginArgs.Wasm = wasm
+		initialWasmHash = sha256.Sum256(wasm)
+	} else if len(args.Wasm) == 0 {
+		return nil, fmt.Errorf("one of wasm or wasm_source must be set")
+	}
+
+	wp, err := NewPlugin(pluginArgs, opts, context.TODO())
 	if err != nil {
 		return nil, err
 	}
 
 	c := &Component{
-		wasm: wp,
-		opts: opts,
-		args: args,
-		ls:   data.(labelstore.LabelStore),
+		wasm:            wp,
+		loki:            loki.NewLogsReceiver(),
+		opts:            opts,
+		args:            args,
+		ls:              data.(labelstore.LabelStore),
+		initialWasmHash: initialWasmHash,
 		timeMetric: prom.NewCounter(prom.CounterOpts{
 			Namespace: "alloy",
 			Subsystem: "compute",
@@ -64,9 +111,49 @@ func New(opts component.Options, args Arguments) (*Component, error) {
 			Subsystem: "compute",
 			Name:      "process_prometheus_records_processed",
 		}),
+		lokiRecordsProcessed: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_loki_records_processed",
+		}),
+		lokiRecordsDropped: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_loki_records_dropped_total",
+			Help:      "Number of transformed log entries dropped because a downstream receiver was not keeping up.",
+		}),
+		wasmReloadFailures: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_wasm_reload_failures_total",
+			Help:      "Number of times reloading the wasm module from wasm_source failed.",
+		}),
+		wasmBatchSize: prom.NewHistogram(prom.HistogramOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_wasm_batch_size",
+			Help:      "Number of samples sent to the guest module per process_batch call.",
+			Buckets:   prom.ExponentialBuckets(1, 2, 12),
+		}),
+		wasmCallDuration: prom.NewHistogram(prom.HistogramOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_wasm_call_duration_seconds",
+			Help:      "Time taken by a single host-to-guest call, including marshaling.",
+			Buckets:   prom.DefBuckets,
+		}),
+		health: component.Health{
+			Health:     component.HealthTypeHealthy,
+			UpdateTime: time.Now(),
+		},
 	}
 	c.opts.Registerer.Register(c.timeMetric)
 	c.opts.Registerer.Register(c.prometheusRecordsProcessed)
+	c.opts.Registerer.Register(c.lokiRecordsProcessed)
+	c.opts.Registerer.Register(c.lokiRecordsDropped)
+	c.opts.Registerer.Register(c.wasmReloadFailures)
+	c.opts.Registerer.Register(c.wasmBatchSize)
+	c.opts.Registerer.Register(c.wasmCallDuration)
 	c.opts.OnStateChange(Exports{
 		PrometheusReceiver: c,
 		LokiReceiver:       c.loki,
@@ -75,28 +162,164 @@ func New(opts component.Options, args Arguments) (*Component, error) {
 }
 
 func (c *Component) Run(ctx context.Context) error {
-	<-ctx.Done()
-	return nil
+	go c.reloadLoop(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.mut.RLock()
+			wasm := c.wasm
+			c.mut.RUnlock()
+			// Close unregisters wasm's metrics and tears down its sandbox
+			// pool (every wazero.Runtime it holds, and the shared
+			// CompilationCache); without it, removing this component from
+			// the config graph would leak the pool and permanently hold the
+			// collector names a re-added component with the same ID needs.
+			if err := wasm.Close(context.WithoutCancel(ctx)); err != nil {
+				level.Error(c.opts.Logger).Log("msg", "failed to close wasm plugin", "err", err)
+			}
+			return nil
+		case entry := <-c.loki.Chan():
+			c.processLogEntry(ctx, entry)
+		}
+	}
 }
 
+// processLogEntry runs a single log entry through the WASM plugin and fans
+// the zero or more transformed entries it returns out to every receiver in
+// args.LokiForwardTo. Sends to a receiver that isn't keeping up are dropped
+// rather than blocking the rest of the pipeline.
+func (c *Component) processLogEntry(ctx context.Context, entry loki.Entry) {
+	c.mut.RLock()
+	wasm := c.wasm
+	c.mut.RUnlock()
+
+	start := time.Now()
+	out, err := wasm.ProcessLogs(ctx, entry.Labels, entry.Entry.Timestamp, entry.Entry.Line)
+	c.timeMetric.Add(float64(time.Since(start).Milliseconds()))
+	if err != nil {
+		level.Error(c.opts.Logger).Log("msg", "failed to process log entry", "err", err)
+		return
+	}
+
+	c.mut.RLock()
+	forwardTo := c.args.LokiForwardTo
+	c.mut.RUnlock()
+
+	for _, e := range out {
+		c.lokiRecordsProcessed.Inc()
+		for _, receiver := range forwardTo {
+			select {
+			case receiver.Chan() <- e:
+			default:
+				c.lokiRecordsDropped.Inc()
+			}
+		}
+	}
+}
+
+// Update stores the new args and, if any field that affects how the sandbox
+// pool is built (Wasm, Config, MaxConcurrency, Limits or OnError) changed,
+// rebuilds the WasmPlugin and atomically swaps it in. Fields read fresh from
+// c.args on every call (e.g. the *ForwardTo and Batch* settings) don't need
+// a rebuild. This is the direct counterpart to the wasm_source polling path
+// in reload.go: that path picks up changes made outside Alloy's config,
+// while this one picks up changes pushed through a normal config reload.
 func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+
 	c.mut.Lock()
-	defer c.mut.Unlock()
+	oldArgs := c.args
+	c.args = newArgs
+	c.mut.Unlock()
 
-	if slices.Equal(c.args.Wasm, args.(Arguments).Wasm) && maps.Equal(c.args.Config, args.(Arguments).Config) {
+	if pluginArgsEqual(oldArgs, newArgs) {
 		return nil
 	}
-	c.args = args.(Arguments)
+
+	// With wasm_source configured, the module bytes come from the source
+	// (carried forward by rebuildWasm), not from the wasm attr.
+	pluginArgs := newArgs
+	if pluginArgs.WasmSource != nil {
+		pluginArgs.Wasm = nil
+	}
+	return c.rebuildWasm(context.Background(), pluginArgs)
+}
+
+// rebuildWasm builds a WasmPlugin from newArgs and atomically swaps it in
+// for c.wasm. The whole read-old/unregister/NewPlugin/swap/closeAll
+// sequence runs under wasmRebuildMut, serializing this against a
+// concurrent call from reload.go's reloadOnce: Update runs on the
+// component controller's goroutine, reloadOnce on reloadLoop's, and an
+// interleaving of the two would unregister the same old plugin's metrics
+// twice, or race two NewPlugins to register a replacement under the same
+// collector names.
+//
+// newArgs.Wasm is only ever populated directly by the user for the wasm
+// attr; when wasm_source is configured it's left empty by Update (New
+// resolves it once up front, and reloadOnce always repopulates it itself
+// before calling in), so the bytes the currently-running plugin already
+// loaded are carried forward instead of being left empty.
+func (c *Component) rebuildWasm(ctx context.Context, newArgs Arguments) error {
+	c.wasmRebuildMut.Lock()
+	defer c.wasmRebuildMut.Unlock()
+
+	c.mut.RLock()
+	old := c.wasm
+	c.mut.RUnlock()
+
+	if newArgs.WasmSource != nil && len(newArgs.Wasm) == 0 {
+		newArgs.Wasm = old.wasm
+	}
+
+	// NewPlugin registers a fresh set of collectors under the same names
+	// old's are already registered under, so old must give those names up
+	// first; if NewPlugin then fails, old is still what's serving calls and
+	// needs its metrics back.
+	old.unregisterMetrics()
+	wp, err := NewPlugin(newArgs, c.opts, ctx)
+	if err != nil {
+		if regErr := old.registerMetrics(); regErr != nil {
+			level.Error(c.opts.Logger).Log("msg", "failed to re-register previous wasm plugin's metrics", "err", regErr)
+		}
+		return err
+	}
+
+	c.mut.Lock()
+	c.wasm = wp
+	c.mut.Unlock()
+	old.pool.closeAll(ctx)
 
 	return nil
 }
 
+// pluginArgsEqual reports whether a and b would produce an equivalent
+// WasmPlugin, i.e. whether Update can skip rebuilding the sandbox pool.
+func pluginArgsEqual(a, b Arguments) bool {
+	return slices.Equal(a.Wasm, b.Wasm) &&
+		maps.Equal(a.Config, b.Config) &&
+		a.MaxConcurrency == b.MaxConcurrency &&
+		a.Limits == b.Limits &&
+		a.OnError == b.OnError
+}
+
 func (c *Component) Appender(ctx context.Context) storage.Appender {
+	c.mut.RLock()
+	wasm := c.wasm
+	forwardTo := c.args.PrometheusForwardTo
+	batchSize := c.args.BatchSize
+	batchMaxDelay := c.args.BatchMaxDelay
+	c.mut.RUnlock()
+
 	return &bulkAppender{
 		ctx:                        ctx,
-		wasm:                       c.wasm,
-		next:                       prometheus.NewFanout(c.args.PrometheusForwardTo, c.opts.ID, c.opts.Registerer, c.ls),
+		wasm:                       wasm,
+		next:                       prometheus.NewFanout(forwardTo, c.opts.ID, c.opts.Registerer, c.ls),
+		batchSize:                  batchSize,
+		batchMaxWait:               batchMaxDelay,
 		timeMetric:                 c.timeMetric,
 		prometheusRecordsProcessed: c.prometheusRecordsProcessed,
+		batchSizeHist:              c.wasmBatchSize,
+		callDuration:               c.wasmCallDuration,
 	}
 }
@@ -0,0 +1,75 @@
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/alloy/internal/component"
+	"github.com/grafana/alloy/internal/component/common/loki"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestComponentForProcessLogEntry builds a Component around wasm, a
+// directly-constructed WasmPlugin whose fake pool instance has no
+// process_logs export, so ProcessLogs takes its passthrough path and
+// processLogEntry can be exercised without a real wasm compile.
+func newTestComponentForProcessLogEntry(t *testing.T, forwardTo []loki.LogsReceiver) *Component {
+	t.Helper()
+	reg := prom.NewRegistry()
+	return &Component{
+		wasm: newTestWasmPlugin(t, reg),
+		args: Arguments{LokiForwardTo: forwardTo},
+		opts: component.Options{Logger: log.NewNopLogger()},
+		timeMetric: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_time_ms",
+		}),
+		lokiRecordsProcessed: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_loki_processed",
+		}),
+		lokiRecordsDropped: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_loki_dropped",
+		}),
+	}
+}
+
+// fillReceiver sends to recv until a send would block, mirroring
+// processLogEntry's own non-blocking send so the test doesn't need to know
+// the receiver's buffer capacity.
+func fillReceiver(recv loki.LogsReceiver) {
+	for {
+		select {
+		case recv.Chan() <- loki.Entry{}:
+		default:
+			return
+		}
+	}
+}
+
+func TestProcessLogEntryFansOutAndDropsOnFullReceiver(t *testing.T) {
+	healthy := loki.NewLogsReceiver()
+	full := loki.NewLogsReceiver()
+	fillReceiver(full)
+
+	c := newTestComponentForProcessLogEntry(t, []loki.LogsReceiver{healthy, full})
+
+	entry := loki.Entry{
+		Labels: labels.FromStrings("job", "test"),
+		Entry:  loki.LogEntry{Timestamp: time.Now(), Line: "hello"},
+	}
+	c.processLogEntry(context.Background(), entry)
+
+	require.Equal(t, float64(1), testutil.ToFloat64(c.lokiRecordsProcessed))
+	require.Equal(t, float64(1), testutil.ToFloat64(c.lokiRecordsDropped))
+
+	select {
+	case got := <-healthy.Chan():
+		require.Equal(t, "hello", got.Entry.Line)
+	default:
+		t.Fatal("expected the transformed entry to be forwarded to the non-full receiver")
+	}
+}
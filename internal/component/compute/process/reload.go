@@ -0,0 +1,193 @@
+package process
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/alloy/internal/component"
+)
+
+// defaultReloadInterval is used when Arguments.ReloadInterval is unset but
+// a WasmSource is configured.
+const defaultReloadInterval = 30 * time.Second
+
+// poolSaturatedThreshold is how long every sandbox instance must have been
+// checked out continuously before the component reports itself degraded.
+const poolSaturatedThreshold = 30 * time.Second
+
+// wasmSourceFetchTimeout bounds how long fetching a wasm_source module over
+// HTTP may take, so a slow or hung server can't wedge New's initial load or
+// stall reloadLoop's single goroutine indefinitely.
+const wasmSourceFetchTimeout = 30 * time.Second
+
+// wasmSourceHTTPClient is used for all wasm_source url fetches.
+var wasmSourceHTTPClient = &http.Client{Timeout: wasmSourceFetchTimeout}
+
+// load fetches the current bytes of the module described by s.
+func (s *WasmSource) load(ctx context.Context) ([]byte, error) {
+	switch {
+	case len(s.Inline) > 0:
+		return s.Inline, nil
+	case s.Path != "":
+		return os.ReadFile(s.Path)
+	case s.URL != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := wasmSourceHTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching wasm module from %s: unexpected status %s", s.URL, resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	default:
+		return nil, fmt.Errorf("wasm_source must set one of path, url or inline")
+	}
+}
+
+// reloadLoop periodically reloads args.WasmSource and atomically swaps
+// c.wasm with the newly compiled module. New already loads WasmSource (or
+// Arguments.Wasm, if that's what's configured) synchronously before the
+// component starts serving, so reloadLoop only has to pick up changes made
+// after startup; it exits once ctx is cancelled, or runs for the lifetime
+// of the component without ever reloading if no WasmSource is configured.
+func (c *Component) reloadLoop(ctx context.Context) {
+	c.mut.RLock()
+	interval := c.args.ReloadInterval
+	c.mut.RUnlock()
+	if interval <= 0 {
+		interval = defaultReloadInterval
+	}
+
+	// Seed with the hash of whatever New already loaded synchronously, so
+	// the first tick doesn't treat an unchanged module as new just because
+	// lastHash starts at its zero value.
+	lastHash := c.initialWasmHash
+	reloadTicker := time.NewTicker(interval)
+	defer reloadTicker.Stop()
+	saturationTicker := time.NewTicker(poolSaturatedThreshold / 3)
+	defer saturationTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reloadTicker.C:
+			c.mut.RLock()
+			src := c.args.WasmSource
+			force := c.args.ReloadOnChange
+			args := c.args
+			c.mut.RUnlock()
+			if src == nil {
+				continue
+			}
+			c.reloadOnce(ctx, src, args, force, &lastHash)
+		case <-saturationTicker.C:
+			c.checkPoolSaturation()
+		}
+	}
+}
+
+// reloadOnce loads src and skips the rebuild below whenever its content
+// hash matches lastHash, so a reload_interval tick that finds nothing new
+// doesn't pay the cost of tearing down and recompiling the sandbox pool.
+// force (Arguments.ReloadOnChange) only adds to that: when set, it makes
+// this reload proceed even though the content is unchanged, rather than
+// gating the unchanged-content skip itself. On any failure the previous
+// module keeps serving appends; the failure is counted and the component
+// is marked degraded.
+func (c *Component) reloadOnce(ctx context.Context, src *WasmSource, args Arguments, force bool, lastHash *[32]byte) {
+	data, err := src.load(ctx)
+	if err != nil {
+		c.reloadFailed(fmt.Errorf("loading wasm module: %w", err))
+		return
+	}
+
+	hash := sha256.Sum256(data)
+	if !force && hash == *lastHash {
+		return
+	}
+	args.Wasm = data
+
+	// rebuildWasm serializes this against a concurrent config-reload
+	// Update; see its doc comment in process.go.
+	if err := c.rebuildWasm(ctx, args); err != nil {
+		c.reloadFailed(fmt.Errorf("compiling reloaded wasm module, keeping previous module: %w", err))
+		return
+	}
+
+	*lastHash = hash
+	c.setHealth(component.Health{
+		Health:     component.HealthTypeHealthy,
+		Message:    "wasm module reloaded",
+		UpdateTime: time.Now(),
+	})
+}
+
+// checkPoolSaturation reflects whether c.wasm's sandbox pool has had every
+// instance checked out continuously for too long, signaling that
+// max_concurrency is too low for the current load. It sets the component
+// degraded while that holds and reverts it to healthy once the pool is no
+// longer saturated, so a transient load spike doesn't leave the component
+// degraded for the rest of its lifetime. c.poolSaturatedHealth tracks that
+// this func (rather than reloadFailed) is the one that last set health, so
+// it doesn't clobber a degraded status left by a failed wasm_source reload.
+func (c *Component) checkPoolSaturation() {
+	c.mut.RLock()
+	wasm := c.wasm
+	c.mut.RUnlock()
+
+	if wasm.Saturated(poolSaturatedThreshold) {
+		c.poolSaturatedHealth = true
+		c.setHealth(component.Health{
+			Health:     component.HealthTypeDegraded,
+			Message:    "wasm sandbox pool has been fully checked out for over 30s; consider raising max_concurrency",
+			UpdateTime: time.Now(),
+		})
+		return
+	}
+
+	if c.poolSaturatedHealth {
+		c.poolSaturatedHealth = false
+		c.setHealth(component.Health{
+			Health:     component.HealthTypeHealthy,
+			Message:    "wasm sandbox pool saturation cleared",
+			UpdateTime: time.Now(),
+		})
+	}
+}
+
+func (c *Component) reloadFailed(err error) {
+	level.Error(c.opts.Logger).Log("msg", "wasm module reload failed", "err", err)
+	c.wasmReloadFailures.Inc()
+	c.poolSaturatedHealth = false
+	c.setHealth(component.Health{
+		Health:     component.HealthTypeDegraded,
+		Message:    err.Error(),
+		UpdateTime: time.Now(),
+	})
+}
+
+// CurrentHealth implements component.HealthComponent, degrading when the
+// most recent wasm module reload attempt failed.
+func (c *Component) CurrentHealth() component.Health {
+	c.healthMut.RLock()
+	defer c.healthMut.RUnlock()
+	return c.health
+}
+
+func (c *Component) setHealth(h component.Health) {
+	c.healthMut.Lock()
+	defer c.healthMut.Unlock()
+	c.health = h
+}
@@ -0,0 +1,138 @@
+package process
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/alloy/internal/component"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmSourceLoadInline(t *testing.T) {
+	s := &WasmSource{Inline: []byte("inline bytes")}
+	data, err := s.load(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []byte("inline bytes"), data)
+}
+
+func TestWasmSourceLoadPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "module.wasm")
+	require.NoError(t, os.WriteFile(path, []byte("from disk"), 0o644))
+
+	s := &WasmSource{Path: path}
+	data, err := s.load(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []byte("from disk"), data)
+}
+
+func TestWasmSourceLoadURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from http"))
+	}))
+	defer srv.Close()
+
+	s := &WasmSource{URL: srv.URL}
+	data, err := s.load(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []byte("from http"), data)
+}
+
+func TestWasmSourceLoadURLNonOK(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	s := &WasmSource{URL: srv.URL}
+	_, err := s.load(context.Background())
+	require.Error(t, err)
+}
+
+func TestWasmSourceLoadRequiresOneField(t *testing.T) {
+	_, err := (&WasmSource{}).load(context.Background())
+	require.Error(t, err)
+}
+
+// newTestComponentForReload builds a Component wired around old, a
+// directly-constructed WasmPlugin, so reloadOnce can be exercised without
+// going through New/NewPlugin.
+func newTestComponentForReload(t *testing.T, old *WasmPlugin) *Component {
+	t.Helper()
+	return &Component{
+		wasm: old,
+		opts: component.Options{
+			Logger:     log.NewNopLogger(),
+			Registerer: old.registerer,
+		},
+		wasmReloadFailures: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_reload_failures",
+		}),
+		health: component.Health{Health: component.HealthTypeHealthy},
+	}
+}
+
+// newTestWasmPlugin builds a WasmPlugin directly, bypassing NewPlugin, so
+// tests don't need a real compiled wasm module to exercise reloadOnce's
+// surrounding bookkeeping.
+func newTestWasmPlugin(t *testing.T, reg prom.Registerer) *WasmPlugin {
+	t.Helper()
+	p := &WasmPlugin{
+		wasm:       []byte("old wasm bytes"),
+		pool:       newTestPool(1),
+		registerer: reg,
+		onError:    "fail",
+		guestMetrics: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_guest_metric",
+		}, []string{"name", "labels"}),
+		guestMetricSeries: make(map[string]struct{}),
+		guestMetricSeriesDropped: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy", Subsystem: "compute", Name: "test_guest_metric_dropped",
+		}),
+	}
+	require.NoError(t, p.registerMetrics())
+	return p
+}
+
+func TestReloadOnceSkipsUnchangedHash(t *testing.T) {
+	reg := prom.NewRegistry()
+	old := newTestWasmPlugin(t, reg)
+	c := newTestComponentForReload(t, old)
+
+	data := []byte("not a real wasm module")
+	lastHash := sha256.Sum256(data)
+
+	// force is false and the hash matches, so reloadOnce must return before
+	// ever trying to compile data as wasm; if it didn't skip, the bogus
+	// bytes above would fail to compile and flip the component degraded.
+	c.reloadOnce(context.Background(), &WasmSource{Inline: data}, Arguments{}, false, &lastHash)
+
+	require.Same(t, old, c.wasm)
+	require.Equal(t, component.HealthTypeHealthy, c.CurrentHealth().Health)
+	require.Equal(t, float64(0), testutil.ToFloat64(c.wasmReloadFailures))
+}
+
+func TestReloadOnceKeepsOldModuleOnCompileFailure(t *testing.T) {
+	reg := prom.NewRegistry()
+	old := newTestWasmPlugin(t, reg)
+	c := newTestComponentForReload(t, old)
+
+	var lastHash [32]byte // zero value never matches, so the load below isn't skipped
+	c.reloadOnce(context.Background(), &WasmSource{Inline: []byte("not a real wasm module")}, Arguments{}, false, &lastHash)
+
+	require.Same(t, old, c.wasm, "old module must keep serving after a compile failure")
+	require.Equal(t, component.HealthTypeDegraded, c.CurrentHealth().Health)
+	require.Equal(t, float64(1), testutil.ToFloat64(c.wasmReloadFailures))
+
+	// old's metrics must still be registered: rebuildWasm unregisters them
+	// before attempting the replacement, and must re-register them on
+	// failure rather than leaving the registry without them.
+	require.Error(t, reg.Register(old.guestMetrics))
+}
@@ -0,0 +1,97 @@
+package process
+
+import (
+	"time"
+
+	"github.com/grafana/alloy/internal/component/common/loki"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// WasmSource describes where the WASM module used by compute.process
+// should be loaded from. Exactly one of Path, URL or Inline should be set;
+// it is re-read on the schedule controlled by Arguments.ReloadInterval and
+// Arguments.ReloadOnChange.
+type WasmSource struct {
+	Path   string `alloy:"path,attr,optional"`
+	URL    string `alloy:"url,attr,optional"`
+	Inline []byte `alloy:"inline,attr,optional"`
+}
+
+// Limits bounds the resources a single call into a pooled guest instance
+// may consume before the instance is recycled.
+type Limits struct {
+	// Fuel is the number of guest-reported compute units (see the
+	// consume_fuel host import) allowed per call. Zero disables the
+	// budget. Enforcement is entirely guest-cooperative: a guest that
+	// never calls consume_fuel is never charged, so Fuel is a budget a
+	// well-behaved guest opts into rather than an enforced ceiling. Timeout
+	// is what actually bounds a guest that doesn't cooperate.
+	Fuel uint64 `alloy:"fuel,attr,optional"`
+	// MemoryBytes caps the guest's linear memory, rounded up to the nearest
+	// wazero page (64KiB). Zero uses the wazero default (no explicit cap
+	// beyond what the module declares); a nonzero value below one page is
+	// rejected rather than silently producing a 0-page limit no guest could
+	// instantiate under.
+	MemoryBytes uint32 `alloy:"memory_bytes,attr,optional"`
+	// Timeout bounds wall-clock time for a single call. Zero falls back to
+	// an internal hard ceiling (see hardCallCeiling in wasm.go) rather than
+	// disabling the backstop outright, so a guest that never calls
+	// consume_fuel can't wedge its sandbox instance's slot forever.
+	Timeout time.Duration `alloy:"timeout,attr,optional"`
+}
+
+// Arguments holds values which are used to configure the compute.process
+// component.
+type Arguments struct {
+	// Wasm is the compiled WebAssembly module that transforms samples and
+	// log lines before they are forwarded downstream. Required unless
+	// WasmSource is set, in which case it is loaded from there instead.
+	Wasm []byte `alloy:"wasm,attr,optional"`
+	// Config is passed through to the guest module unmodified, allowing the
+	// plugin to be parameterized without recompiling it.
+	Config map[string]string `alloy:"config,attr,optional"`
+
+	// WasmSource, when set, lets the module be loaded from disk or HTTP
+	// instead of being supplied inline via Wasm, and hot-reloaded without
+	// restarting the component. It is loaded synchronously once in New so
+	// the component never has to run a throwaway Wasm while waiting for
+	// the first reloadLoop tick, and takes precedence over Wasm from then
+	// on.
+	WasmSource     *WasmSource   `alloy:"wasm_source,block,optional"`
+	ReloadInterval time.Duration `alloy:"reload_interval,attr,optional"`
+	// ReloadOnChange forces every reload_interval tick to rebuild the
+	// sandbox pool even when WasmSource's content hash hasn't changed since
+	// the last reload. A tick that finds an unchanged hash always skips the
+	// rebuild otherwise, so this is only useful to force a fresh pool (e.g.
+	// to reset guest state) on a schedule.
+	ReloadOnChange bool `alloy:"reload_on_change,attr,optional"`
+
+	// BatchSize and BatchMaxDelay bound how many samples are buffered
+	// before a single call crosses into the guest module; whichever limit
+	// is hit first triggers the call. A zero BatchSize disables the size
+	// bound (batches flush only on BatchMaxDelay or Commit); a zero
+	// BatchMaxDelay disables the latency bound.
+	BatchSize     int           `alloy:"batch_size,attr,optional"`
+	BatchMaxDelay time.Duration `alloy:"batch_max_delay,attr,optional"`
+
+	// MaxConcurrency is the number of pre-instantiated guest instances kept
+	// ready in the sandbox pool; appenders check one out rather than
+	// contending on a single shared module. Defaults to runtime.NumCPU().
+	MaxConcurrency int `alloy:"max_concurrency,attr,optional"`
+	// Limits bounds how much a single call into the guest is allowed to
+	// cost before the instance is considered misbehaving.
+	Limits Limits `alloy:"limits,block,optional"`
+	// OnError controls what happens to a batch whose instance was dropped
+	// for exceeding Limits: "fail" (default) returns an error, while
+	// "passthrough" forwards the batch unchanged.
+	OnError string `alloy:"on_error,attr,optional"`
+
+	PrometheusForwardTo []storage.Appendable `alloy:"prometheus_forward_to,attr,optional"`
+	LokiForwardTo       []loki.LogsReceiver  `alloy:"loki_forward_to,attr,optional"`
+}
+
+// Exports holds values which are exported by the compute.process component.
+type Exports struct {
+	PrometheusReceiver storage.Appendable `alloy:"prometheus_receiver,attr"`
+	LokiReceiver       loki.LogsReceiver  `alloy:"loki_receiver,attr"`
+}
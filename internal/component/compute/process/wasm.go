@@ -0,0 +1,481 @@
+package process
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/alloy/internal/component"
+	"github.com/grafana/alloy/internal/component/common/loki"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// maxGuestMetricSeries bounds the number of distinct name/labels
+// combinations a guest can register through metric_emit (see
+// admitGuestMetricSeries).
+const maxGuestMetricSeries = 1000
+
+// WasmPlugin wraps a compiled WebAssembly module used to transform
+// Prometheus samples and Loki log lines before they are forwarded
+// downstream. Calls are served by a pool of isolated sandbox instances
+// (see pool.go) rather than a single shared module, so concurrent
+// appenders don't serialize on one another.
+type WasmPlugin struct {
+	wasm []byte
+	pool *instancePool
+
+	cfg        map[string]string
+	logger     log.Logger
+	registerer prom.Registerer
+	limits     Limits
+	onError    string
+
+	guestMetrics             *prom.GaugeVec
+	guestMetricSeriesMut     sync.Mutex
+	guestMetricSeries        map[string]struct{}
+	guestMetricSeriesDropped prom.Counter
+}
+
+// NewPlugin compiles wasm and boots a pool of pre-instantiated sandbox
+// instances for it. args.Config is made available to the guest through
+// the config_get host import; opts supplies the logger and registerer
+// used by the alloy_compute host imports and by the pool's own metrics.
+func NewPlugin(args Arguments, opts component.Options, ctx context.Context) (*WasmPlugin, error) {
+	onError := args.OnError
+	if onError == "" {
+		onError = "fail"
+	}
+	if onError != "fail" && onError != "passthrough" {
+		return nil, fmt.Errorf("on_error must be %q or %q, got %q", "fail", "passthrough", onError)
+	}
+	if mb := args.Limits.MemoryBytes; mb > 0 && mb < wasmPageSize {
+		return nil, fmt.Errorf("limits.memory_bytes must be at least %d (one wasm page), got %d", wasmPageSize, mb)
+	}
+
+	p := &WasmPlugin{
+		wasm:       args.Wasm,
+		cfg:        args.Config,
+		logger:     opts.Logger,
+		registerer: opts.Registerer,
+		limits:     args.Limits,
+		onError:    onError,
+		guestMetrics: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_wasm_guest_metric",
+			Help:      "Ad-hoc gauges emitted by the guest module via the metric_emit host import.",
+		}, []string{"name", "labels"}),
+		guestMetricSeries: make(map[string]struct{}),
+		guestMetricSeriesDropped: prom.NewCounter(prom.CounterOpts{
+			Namespace: "alloy",
+			Subsystem: "compute",
+			Name:      "process_wasm_guest_metric_series_dropped_total",
+			Help:      "Number of metric_emit calls dropped because the guest exceeded its distinct name/labels series limit.",
+		}),
+	}
+
+	pool, err := newInstancePool(ctx, p, args.Wasm, args.MaxConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	p.pool = pool
+
+	if err := p.registerMetrics(); err != nil {
+		pool.closeAll(ctx)
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// registerMetrics registers every prom.Collector owned by p (and its pool)
+// on p.registerer. Callers that are replacing an existing plugin must
+// unregister the old one's metrics first (see unregisterMetrics): the
+// collectors below share names across every generation of WasmPlugin, so
+// registering a new generation's while an old one is still registered
+// always fails.
+func (p *WasmPlugin) registerMetrics() error {
+	for _, c := range []prom.Collector{p.guestMetrics, p.guestMetricSeriesDropped, p.pool.inUse, p.pool.recycled, p.pool.timeouts} {
+		if err := p.registerer.Register(c); err != nil {
+			return fmt.Errorf("registering wasm plugin metric: %w", err)
+		}
+	}
+	return nil
+}
+
+// unregisterMetrics undoes registerMetrics, freeing the collector names up
+// for a replacement plugin to register.
+func (p *WasmPlugin) unregisterMetrics() {
+	p.registerer.Unregister(p.guestMetrics)
+	p.registerer.Unregister(p.guestMetricSeriesDropped)
+	p.registerer.Unregister(p.pool.inUse)
+	p.registerer.Unregister(p.pool.recycled)
+	p.registerer.Unregister(p.pool.timeouts)
+}
+
+// Close unregisters the metrics registered on the plugin's behalf and
+// releases every sandbox instance in the pool, so a replacement plugin can
+// register its own metrics and the pool's resources are freed. Calling
+// Close on a plugin whose metrics were already unregistered via
+// unregisterMetrics is safe: Unregister is a no-op on an
+// already-unregistered collector. rebuildWasm (used by Update and
+// reloadOnce) does not call Close; it unregisters the old plugin's
+// metrics itself and closes its pool with closeAll.
+func (p *WasmPlugin) Close(ctx context.Context) error {
+	p.unregisterMetrics()
+	p.pool.closeAll(ctx)
+	return nil
+}
+
+// Saturated reports whether the pool has had every instance checked out
+// continuously for at least d.
+func (p *WasmPlugin) Saturated(d time.Duration) bool {
+	return p.pool.saturated(d)
+}
+
+// admitGuestMetricSeries reports whether hostMetricEmit may set the guest
+// metric series identified by name and labels, capping the number of
+// distinct series at maxGuestMetricSeries. A series already seen is always
+// admitted; a new one is only admitted while under the cap. Without this,
+// a buggy or hostile guest could grow the host's metric registry without
+// bound simply by varying its label values.
+func (p *WasmPlugin) admitGuestMetricSeries(name, labelStr string) bool {
+	key := name + "\x00" + labelStr
+
+	p.guestMetricSeriesMut.Lock()
+	defer p.guestMetricSeriesMut.Unlock()
+
+	if _, seen := p.guestMetricSeries[key]; seen {
+		return true
+	}
+	if len(p.guestMetricSeries) >= maxGuestMetricSeries {
+		return false
+	}
+	p.guestMetricSeries[key] = struct{}{}
+	return true
+}
+
+// hardCallCeiling is the deadline applied to a call into a guest instance
+// when limits.Timeout is unset (0). Fuel accounting is guest-cooperative
+// (see hostConsumeFuel): a guest that never calls consume_fuel, whether by
+// bug or by design, would otherwise run unbounded and permanently strand
+// its instance's slot if limits.Timeout were also left at 0 with no
+// fallback. This ceiling is what
+// actually interrupts that guest: each instance's wazero.Runtime is built
+// WithCloseOnContextDone(true), so once callCtx's deadline fires wazero
+// tears the runtime down out from under the in-flight call, which returns
+// an error and lets release() recycle the slot instead of leaking it.
+const hardCallCeiling = 30 * time.Second
+
+// checkout checks out an instance and returns a context bounded by
+// limits.Timeout, or by hardCallCeiling if limits.Timeout is unset, along
+// with a release func that the caller must invoke with whether the call
+// that follows is considered to have misbehaved the instance.
+func (p *WasmPlugin) checkout(ctx context.Context) (*wasmInstance, context.Context, func(bad bool), error) {
+	inst, err := p.pool.checkout(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	timeout := p.limits.Timeout
+	if timeout <= 0 {
+		timeout = hardCallCeiling
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+
+	release := func(bad bool) {
+		cancel()
+		if callCtx.Err() == context.DeadlineExceeded {
+			p.pool.timeouts.Inc()
+			bad = true
+		}
+		p.pool.release(context.WithoutCancel(ctx), inst, bad)
+	}
+	return inst, callCtx, release, nil
+}
+
+// onInstanceError applies args.OnError to a call that failed because its
+// instance was dropped: "fail" propagates err, "passthrough" forwards
+// fallback unchanged and swallows err.
+func onInstanceError[T any](p *WasmPlugin, err error, fallback T) (T, error) {
+	if p.onError == "passthrough" {
+		return fallback, nil
+	}
+	var zero T
+	return zero, err
+}
+
+// packLine marshals a label set and line into the wire format expected by
+// the guest's process_logs export: a 4-byte little-endian length-prefixed
+// label string ("k1=v1,k2=v2", keys sorted), an 8-byte little-endian
+// unix-nano timestamp, and a 4-byte little-endian length-prefixed line.
+func packLine(ls labels.Labels, ts time.Time, line string) []byte {
+	pairs := make([]string, 0, len(ls))
+	for _, l := range ls {
+		pairs = append(pairs, l.Name+"="+l.Value)
+	}
+	sort.Strings(pairs)
+	labelStr := strings.Join(pairs, ",")
+
+	buf := make([]byte, 4+len(labelStr)+8+4+len(line))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(labelStr)))
+	off := 4
+	copy(buf[off:], labelStr)
+	off += len(labelStr)
+	binary.LittleEndian.PutUint64(buf[off:off+8], uint64(ts.UnixNano()))
+	off += 8
+	binary.LittleEndian.PutUint32(buf[off:off+4], uint32(len(line)))
+	off += 4
+	copy(buf[off:], line)
+	return buf
+}
+
+// unpackEntries parses the length-prefixed buffer of transformed log
+// entries returned by the guest's process_logs export. Each entry has the
+// same layout produced by packLine, allowing 0..N outputs per input.
+func unpackEntries(buf []byte) ([]loki.Entry, error) {
+	var out []loki.Entry
+	for len(buf) > 0 {
+		if len(buf) < 4 {
+			return nil, fmt.Errorf("truncated entry header")
+		}
+		labelLen := binary.LittleEndian.Uint32(buf[0:4])
+		buf = buf[4:]
+		if uint32(len(buf)) < labelLen+8 {
+			return nil, fmt.Errorf("truncated entry body")
+		}
+		labelStr := string(buf[:labelLen])
+		buf = buf[labelLen:]
+		tsNano := binary.LittleEndian.Uint64(buf[:8])
+		buf = buf[8:]
+
+		// The guest does not know the length of the line it's returning up
+		// front, so it is length-prefixed the same way the label set is.
+		if len(buf) < 4 {
+			return nil, fmt.Errorf("truncated line header")
+		}
+		lineLen := binary.LittleEndian.Uint32(buf[0:4])
+		buf = buf[4:]
+		if uint32(len(buf)) < lineLen {
+			return nil, fmt.Errorf("truncated line body")
+		}
+		line := string(buf[:lineLen])
+		buf = buf[lineLen:]
+
+		out = append(out, loki.Entry{
+			Labels: parseLabelString(labelStr),
+			Entry: loki.LogEntry{
+				Timestamp: time.Unix(0, int64(tsNano)),
+				Line:      line,
+			},
+		})
+	}
+	return out, nil
+}
+
+func parseLabelString(s string) labels.Labels {
+	if s == "" {
+		return labels.EmptyLabels()
+	}
+	pairs := strings.Split(s, ",")
+	b := labels.NewBuilder(labels.EmptyLabels())
+	for _, p := range pairs {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		b.Set(kv[0], kv[1])
+	}
+	return b.Labels()
+}
+
+// unpackUint64Result splits a packed (ptr<<32|len) result, the convention
+// used by every entry point that returns a variable-length buffer without
+// an out parameter. A zero-length result does not imply ptr is zero: a
+// guest that mallocs a buffer to encode "no output" (the filtering case
+// every process_* entry point supports) still owns that allocation, so
+// callers must free ptr whenever it is non-zero regardless of length.
+func unpackUint64Result(packed uint64) (ptr, length uint32) {
+	return uint32(packed >> 32), uint32(packed)
+}
+
+// Sample is a single Prometheus sample returned by ProcessMetrics or
+// ProcessBatch.
+type Sample struct {
+	Labels    labels.Labels
+	Timestamp int64
+	Value     float64
+	// Exemplars are populated by ProcessBatch when the guest attaches one
+	// or more exemplars to an output sample; ProcessMetrics never sets it.
+	Exemplars []Exemplar
+}
+
+// packSample marshals a label set, timestamp and value into the wire
+// format expected by process_metrics: a 4-byte little-endian
+// length-prefixed label string, an 8-byte little-endian unix-milli
+// timestamp, and an 8-byte IEEE754 value.
+func packSample(ls labels.Labels, t int64, v float64) []byte {
+	pairs := make([]string, 0, len(ls))
+	for _, l := range ls {
+		pairs = append(pairs, l.Name+"="+l.Value)
+	}
+	sort.Strings(pairs)
+	labelStr := strings.Join(pairs, ",")
+
+	buf := make([]byte, 4+len(labelStr)+8+8)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(labelStr)))
+	off := 4
+	copy(buf[off:], labelStr)
+	off += len(labelStr)
+	binary.LittleEndian.PutUint64(buf[off:off+8], uint64(t))
+	off += 8
+	binary.LittleEndian.PutUint64(buf[off:off+8], math.Float64bits(v))
+	return buf
+}
+
+// ProcessMetrics hands a single sample to the guest module and returns
+// zero or more transformed samples, mirroring the filtering/fan-out
+// semantics of ProcessLogs. On an instance fault it applies args.OnError.
+func (p *WasmPlugin) ProcessMetrics(ctx context.Context, ls labels.Labels, t int64, v float64) ([]Sample, error) {
+	inst, callCtx, release, err := p.checkout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	samples, faulted, err := p.processMetricsOn(callCtx, inst, ls, t, v)
+	release(faulted)
+	return samples, err
+}
+
+// processMetricsOn runs the process_metrics protocol against an
+// already-checked-out instance; callers own checking it out and releasing
+// it afterward (see ProcessMetrics and ProcessBatch's legacy-guest path).
+// faulted reports whether the instance misbehaved regardless of whether
+// args.OnError ended up surfacing that as err to the caller.
+func (p *WasmPlugin) processMetricsOn(ctx context.Context, inst *wasmInstance, ls labels.Labels, t int64, v float64) ([]Sample, bool, error) {
+	fallback := []Sample{{Labels: ls, Timestamp: t, Value: v}}
+
+	if inst.processMetrics == nil {
+		return fallback, false, nil
+	}
+
+	in := packSample(ls, t, v)
+	inPtr, err := inst.writeBytes(ctx, in)
+	if err != nil {
+		samples, err := onInstanceError(p, err, fallback)
+		return samples, true, err
+	}
+	defer inst.free.Call(ctx, inPtr)
+
+	res, err := inst.processMetrics.Call(ctx, inPtr, uint64(len(in)))
+	if err != nil {
+		samples, err := onInstanceError(p, fmt.Errorf("calling process_metrics: %w", err), fallback)
+		return samples, true, err
+	}
+	outPtr, outLen := unpackUint64Result(res[0])
+	if outPtr != 0 {
+		defer inst.free.Call(ctx, uint64(outPtr))
+	}
+	if outLen == 0 {
+		return nil, false, nil
+	}
+
+	out, err := inst.readBytes(outPtr, outLen)
+	if err != nil {
+		samples, err := onInstanceError(p, err, fallback)
+		return samples, true, err
+	}
+	samples, err := unpackSamples(out)
+	if err != nil {
+		samples, err := onInstanceError(p, err, fallback)
+		return samples, true, err
+	}
+	return samples, false, nil
+}
+
+func unpackSamples(buf []byte) ([]Sample, error) {
+	var out []Sample
+	for len(buf) > 0 {
+		if len(buf) < 4 {
+			return nil, fmt.Errorf("truncated sample header")
+		}
+		labelLen := binary.LittleEndian.Uint32(buf[0:4])
+		buf = buf[4:]
+		if uint32(len(buf)) < labelLen+16 {
+			return nil, fmt.Errorf("truncated sample body")
+		}
+		labelStr := string(buf[:labelLen])
+		buf = buf[labelLen:]
+		ts := int64(binary.LittleEndian.Uint64(buf[:8]))
+		buf = buf[8:]
+		bits := binary.LittleEndian.Uint64(buf[:8])
+		buf = buf[8:]
+
+		out = append(out, Sample{
+			Labels:    parseLabelString(labelStr),
+			Timestamp: ts,
+			Value:     math.Float64frombits(bits),
+		})
+	}
+	return out, nil
+}
+
+// ProcessLogs hands a single label set + line to the guest module and
+// returns zero or more transformed entries, supporting both filtering
+// (return nothing) and fan-out (return multiple entries per input). On an
+// instance fault it applies args.OnError. Guests that don't export
+// process_logs (e.g. metrics-only modules predating this pipeline) get the
+// entry back unchanged rather than failing to load.
+func (p *WasmPlugin) ProcessLogs(ctx context.Context, ls labels.Labels, ts time.Time, line string) ([]loki.Entry, error) {
+	fallback := []loki.Entry{{Labels: ls, Entry: loki.LogEntry{Timestamp: ts, Line: line}}}
+
+	inst, callCtx, release, err := p.checkout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bad := false
+	defer func() { release(bad) }()
+
+	if inst.processLogs == nil {
+		return fallback, nil
+	}
+
+	in := packLine(ls, ts, line)
+	inPtr, err := inst.writeBytes(callCtx, in)
+	if err != nil {
+		bad = true
+		return onInstanceError(p, err, fallback)
+	}
+	defer inst.free.Call(callCtx, inPtr)
+
+	res, err := inst.processLogs.Call(callCtx, inPtr, uint64(len(in)))
+	if err != nil {
+		bad = true
+		return onInstanceError(p, fmt.Errorf("calling process_logs: %w", err), fallback)
+	}
+	outPtr, outLen := unpackUint64Result(res[0])
+	if outPtr != 0 {
+		defer inst.free.Call(callCtx, uint64(outPtr))
+	}
+	if outLen == 0 {
+		return nil, nil
+	}
+
+	out, err := inst.readBytes(outPtr, outLen)
+	if err != nil {
+		bad = true
+		return onInstanceError(p, err, fallback)
+	}
+	entries, err := unpackEntries(out)
+	if err != nil {
+		bad = true
+		return onInstanceError(p, err, fallback)
+	}
+	return entries, nil
+}
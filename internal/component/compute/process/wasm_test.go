@@ -0,0 +1,77 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackUnpackLineRoundTrip(t *testing.T) {
+	ls := labels.FromStrings("job", "node", "instance", "localhost:9090")
+	ts := time.Unix(0, 1_700_000_000_000).UTC()
+	line := "hello world"
+
+	buf := packLine(ls, ts, line)
+
+	entries, err := unpackEntries(buf)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.True(t, labels.Equal(ls, entries[0].Labels))
+	require.Equal(t, ts.UnixNano(), entries[0].Entry.Timestamp.UnixNano())
+	require.Equal(t, line, entries[0].Entry.Line)
+}
+
+func TestUnpackEntriesMultiple(t *testing.T) {
+	a := packLine(labels.FromStrings("a", "1"), time.Unix(0, 10), "first")
+	b := packLine(labels.FromStrings("b", "2"), time.Unix(0, 20), "second")
+
+	entries, err := unpackEntries(append(a, b...))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "first", entries[0].Entry.Line)
+	require.Equal(t, "second", entries[1].Entry.Line)
+}
+
+func TestUnpackEntriesEmpty(t *testing.T) {
+	entries, err := unpackEntries(nil)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestUnpackEntriesTruncated(t *testing.T) {
+	_, err := unpackEntries([]byte{1, 2, 3})
+	require.Error(t, err)
+}
+
+func TestPackUnpackSampleRoundTrip(t *testing.T) {
+	ls := labels.FromStrings("__name__", "up")
+	buf := packSample(ls, 1234, 5.5)
+
+	samples, err := unpackSamples(buf)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	require.True(t, labels.Equal(ls, samples[0].Labels))
+	require.Equal(t, int64(1234), samples[0].Timestamp)
+	require.Equal(t, 5.5, samples[0].Value)
+}
+
+func TestUnpackSamplesTruncated(t *testing.T) {
+	_, err := unpackSamples([]byte{0, 0, 0})
+	require.Error(t, err)
+}
+
+func TestParseLabelString(t *testing.T) {
+	require.True(t, labels.Equal(labels.EmptyLabels(), parseLabelString("")))
+
+	ls := parseLabelString("a=1,b=2")
+	require.True(t, labels.Equal(labels.FromStrings("a", "1", "b", "2"), ls))
+}
+
+func TestPackUnpackUint64Result(t *testing.T) {
+	packed := packUint64Result(0x1234, 0x5678)
+	ptr, length := unpackUint64Result(packed)
+	require.Equal(t, uint32(0x1234), ptr)
+	require.Equal(t, uint32(0x5678), length)
+}